@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* =========================
+   RESULT CACHE
+========================= */
+
+// The cache is a JSON file per (cx, query) pair, sharded into
+// subdirectories by the first two hex characters of the key so a single
+// directory never grows unreasonably large.
+const cacheDir = "./cache"
+
+var (
+	cacheFreshTTL = 24 * time.Hour
+	cacheStaleTTL = 7 * 24 * time.Hour
+	cacheTTLMu    sync.RWMutex
+	cacheMu       sync.Mutex
+)
+
+func init() {
+	if v := os.Getenv("CACHE_FRESH_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cacheFreshTTL = d
+		}
+	}
+	if v := os.Getenv("CACHE_STALE_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cacheStaleTTL = d
+		}
+	}
+}
+
+// getCacheTTLs returns the active fresh/stale TTLs. applyConfig (config.go)
+// can change these on a hot-reload from a request-handling goroutine, so
+// every read/write goes through cacheTTLMu rather than touching the vars
+// directly.
+func getCacheTTLs() (fresh, stale time.Duration) {
+	cacheTTLMu.RLock()
+	defer cacheTTLMu.RUnlock()
+	return cacheFreshTTL, cacheStaleTTL
+}
+
+// setCacheTTLs updates whichever of fresh/stale is non-zero, leaving the
+// other at its current value.
+func setCacheTTLs(fresh, stale time.Duration) {
+	cacheTTLMu.Lock()
+	defer cacheTTLMu.Unlock()
+	if fresh > 0 {
+		cacheFreshTTL = fresh
+	}
+	if stale > 0 {
+		cacheStaleTTL = stale
+	}
+}
+
+type cacheEntry struct {
+	Images    []string  `json:"images"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func normalizeQuery(query string) string {
+	return strings.ToLower(strings.TrimSpace(query))
+}
+
+func cacheKey(cx, query string) string {
+	sum := sha256.Sum256([]byte(cx + "|" + normalizeQuery(query)))
+	return hex.EncodeToString(sum[:])
+}
+
+func cachePath(key string) string {
+	return filepath.Join(cacheDir, key[:2], key+".json")
+}
+
+func readCacheEntry(key string) (*cacheEntry, bool) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	data, err := ioutil.ReadFile(cachePath(key))
+	if err != nil {
+		return nil, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false
+	}
+	return &entry, true
+}
+
+func writeCacheEntry(key string, images []string) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	path := cachePath(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return
+	}
+	data, err := json.Marshal(cacheEntry{Images: images, Timestamp: time.Now()})
+	if err != nil {
+		return
+	}
+	ioutil.WriteFile(path, data, 0o644)
+}
+
+func deleteCacheEntry(key string) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	os.Remove(cachePath(key))
+}
+
+/* =========================
+   CACHED IMAGE FETCH
+========================= */
+
+// fetchImages is getImages/searchWithEngines fronted by the on-disk
+// cache: fresh hits return immediately, stale hits return immediately but
+// trigger an async refresh, and misses (or anything past StaleTTL) fetch
+// synchronously.
+func fetchImages(ctx context.Context, query, cx string, limit int, engines []string, mode string) ([]string, error) {
+	key := cacheKey(cx, query)
+
+	freshTTL, staleTTL := getCacheTTLs()
+
+	if entry, ok := readCacheEntry(key); ok {
+		age := time.Since(entry.Timestamp)
+		if age < freshTTL {
+			return entry.Images, nil
+		}
+		if age < staleTTL {
+			go func() {
+				refreshCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				defer cancel()
+				if imgs, err := searchWithEngines(refreshCtx, query, cx, limit, engines, mode); err == nil {
+					writeCacheEntry(key, imgs)
+				}
+			}()
+			return entry.Images, nil
+		}
+	}
+
+	imgs, err := searchWithEngines(ctx, query, cx, limit, engines, mode)
+	if err != nil {
+		return nil, err
+	}
+	writeCacheEntry(key, imgs)
+	return imgs, nil
+}
+
+/* =========================
+   CACHE ADMIN ENDPOINT
+========================= */
+
+func cacheAdminHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", 405)
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("query"))
+	if query == "" {
+		http.Error(w, "query parameter required", 400)
+		return
+	}
+
+	if cx := r.URL.Query().Get("cx"); cx != "" {
+		deleteCacheEntry(cacheKey(cx, query))
+		w.WriteHeader(204)
+		return
+	}
+
+	if category := r.URL.Query().Get("category"); category != "" {
+		if cx := resolveCX(category); cx != "" {
+			deleteCacheEntry(cacheKey(cx, query))
+		}
+		w.WriteHeader(204)
+		return
+	}
+
+	// No cx/category given: drop the cache entry for every known category.
+	for _, category := range knownCategories() {
+		if cx := resolveCX(category); cx != "" {
+			deleteCacheEntry(cacheKey(cx, query))
+		}
+	}
+	w.WriteHeader(204)
+}
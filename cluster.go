@@ -0,0 +1,571 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/* =========================
+   PEER PROTOCOL
+
+   Length-prefixed JSON messages over a plain TCP connection: a 4-byte
+   big-endian length followed by that many bytes of JSON. Every message
+   carries an HMAC over its type/node/request so a peer link can't be
+   spoofed by anyone without the shared cluster_secret.
+========================= */
+
+type peerMessage struct {
+	Type      string            `json:"type"` // HELLO, QUERY, QUERY_RESULT, HEARTBEAT, QUOTA_STATE
+	NodeID    string            `json:"node_id"`
+	RequestID string            `json:"request_id,omitempty"`
+	Query     string            `json:"query,omitempty"`
+	CX        string            `json:"cx,omitempty"`
+	Limit     int               `json:"limit,omitempty"`
+	Engines   []string          `json:"engines,omitempty"`
+	Mode      string            `json:"mode,omitempty"`
+	Images    []string          `json:"images,omitempty"`
+	Err       string            `json:"err,omitempty"`
+	Remaining float64           `json:"remaining,omitempty"`
+	DeadUntil map[string]string `json:"dead_until,omitempty"`
+	HMAC      string            `json:"hmac"`
+}
+
+// signaturePayload canonicalizes m (with HMAC cleared) to JSON so the MAC
+// covers every field — including Images/Err/Remaining/DeadUntil — not just
+// the routing fields. Struct field order makes json.Marshal's output
+// deterministic, so the same message always canonicalizes the same way.
+func signaturePayload(m peerMessage) []byte {
+	m.HMAC = ""
+	data, _ := json.Marshal(m)
+	return data
+}
+
+func signMessage(m *peerMessage, secret string) {
+	m.HMAC = ""
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(signaturePayload(*m))
+	m.HMAC = hex.EncodeToString(mac.Sum(nil))
+}
+
+func verifyMessage(m peerMessage, secret string) bool {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(signaturePayload(m))
+	expected := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(m.HMAC))
+}
+
+func writeMessage(w *bufio.Writer, m peerMessage) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(data)))
+	if _, err := w.Write(length[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// maxFrameSize bounds a peer message's declared length. It's generous
+// for a JSON envelope carrying a batch of image URLs, but it keeps an
+// unauthenticated sender (anyone who can reach ClusterListenAddr, not
+// just someone holding the cluster secret) from forcing a multi-GB
+// allocation before verifyMessage ever runs.
+const maxFrameSize = 512 * 1024
+
+func readMessage(r *bufio.Reader) (peerMessage, error) {
+	var length [4]byte
+	if _, err := ioReadFull(r, length[:]); err != nil {
+		return peerMessage{}, err
+	}
+	n := binary.BigEndian.Uint32(length[:])
+	if n > maxFrameSize {
+		return peerMessage{}, fmt.Errorf("cluster: frame of %d bytes exceeds max %d", n, maxFrameSize)
+	}
+	buf := make([]byte, n)
+	if _, err := ioReadFull(r, buf); err != nil {
+		return peerMessage{}, err
+	}
+	var m peerMessage
+	if err := json.Unmarshal(buf, &m); err != nil {
+		return peerMessage{}, err
+	}
+	return m, nil
+}
+
+func ioReadFull(r *bufio.Reader, buf []byte) (int, error) {
+	total := 0
+	for total < len(buf) {
+		n, err := r.Read(buf[total:])
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+/* =========================
+   CLUSTER STATE
+========================= */
+
+type peerInfo struct {
+	id   string
+	addr string
+
+	mu        sync.Mutex
+	writer    *bufio.Writer
+	remaining float64
+	deadUntil map[string]string
+	lastSeen  time.Time
+}
+
+type cluster struct {
+	selfID string
+	secret string
+
+	mu      sync.Mutex
+	peers   map[string]*peerInfo
+	pending map[string]chan peerMessage
+	conns   map[net.Conn]struct{}
+
+	listener net.Listener
+	stopCh   chan struct{}
+	stopOnce sync.Once
+
+	reqCounter int64
+}
+
+var (
+	activeCluster   *cluster
+	activeClusterMu sync.Mutex
+)
+
+func getCluster() *cluster {
+	activeClusterMu.Lock()
+	defer activeClusterMu.Unlock()
+	return activeCluster
+}
+
+// startCluster brings up the peer TCP server (if cluster_listen_addr is
+// set) and dials every configured peer, replacing whatever cluster was
+// previously active (stopping its listener, dial loops, and open
+// connections first). Callers should only invoke this when the
+// cluster-relevant config actually changed — see clusterSignature in
+// config.go — since every call means a fresh TCP listener and a round of
+// peer reconnects.
+func startCluster(cfg *Config) {
+	if old := getCluster(); old != nil {
+		old.stop()
+	}
+
+	c := &cluster{
+		selfID:  cfg.SelfAddr,
+		secret:  cfg.ClusterSecret,
+		peers:   make(map[string]*peerInfo),
+		pending: make(map[string]chan peerMessage),
+		conns:   make(map[net.Conn]struct{}),
+		stopCh:  make(chan struct{}),
+	}
+	if c.selfID == "" {
+		c.selfID = "self"
+	}
+
+	activeClusterMu.Lock()
+	activeCluster = c
+	activeClusterMu.Unlock()
+
+	if cfg.ClusterListenAddr != "" {
+		go c.listen(cfg.ClusterListenAddr)
+	}
+	for _, addr := range cfg.Peers {
+		go c.dial(addr)
+	}
+	go c.heartbeatLoop()
+}
+
+// stop tears down every goroutine startCluster spun up: it unblocks
+// listen()'s Accept, stops dial()'s reconnect loops, and closes every
+// open peer connection so their handleConn readers exit too. Safe to
+// call more than once.
+func (c *cluster) stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopCh)
+
+		c.mu.Lock()
+		if c.listener != nil {
+			c.listener.Close()
+		}
+		for conn := range c.conns {
+			conn.Close()
+		}
+		c.mu.Unlock()
+	})
+}
+
+func (c *cluster) stopped() bool {
+	select {
+	case <-c.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *cluster) listen(addr string) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		fmt.Println("cluster: listen failed:", err)
+		return
+	}
+	c.mu.Lock()
+	c.listener = ln
+	c.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if c.stopped() {
+				return
+			}
+			continue
+		}
+		go c.handleConn(conn, "")
+	}
+}
+
+func (c *cluster) dial(addr string) {
+	for !c.stopped() {
+		conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+		if err != nil {
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		c.handleConn(conn, addr)
+		if c.stopped() {
+			return
+		}
+		time.Sleep(5 * time.Second) // reconnect after the peer drops
+	}
+}
+
+// handleConn services one peer connection in both directions: it reads
+// the peer's messages in a loop and also registers a writer any other
+// goroutine (proxyQuery, heartbeatLoop) can use to send to this peer.
+func (c *cluster) handleConn(conn net.Conn, dialedAddr string) {
+	c.mu.Lock()
+	c.conns[conn] = struct{}{}
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.conns, conn)
+		c.mu.Unlock()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+
+	hello := peerMessage{Type: "HELLO", NodeID: c.selfID}
+	signMessage(&hello, c.secret)
+	if err := writeMessage(writer, hello); err != nil {
+		return
+	}
+
+	var peerID string
+	var info *peerInfo
+
+	for {
+		msg, err := readMessage(reader)
+		if err != nil {
+			if info != nil {
+				c.mu.Lock()
+				delete(c.peers, info.id)
+				c.mu.Unlock()
+			}
+			return
+		}
+
+		if !verifyMessage(msg, c.secret) {
+			continue
+		}
+
+		if info == nil {
+			peerID = msg.NodeID
+			if peerID == "" {
+				peerID = dialedAddr
+			}
+			info = &peerInfo{id: peerID, addr: dialedAddr, writer: writer, lastSeen: time.Now()}
+			c.mu.Lock()
+			c.peers[peerID] = info
+			c.mu.Unlock()
+		}
+
+		switch msg.Type {
+		case "HELLO":
+			// nothing further to do; the connection is now registered above.
+
+		case "HEARTBEAT":
+			info.mu.Lock()
+			info.lastSeen = time.Now()
+			info.mu.Unlock()
+
+		case "QUOTA_STATE":
+			info.mu.Lock()
+			info.remaining = msg.Remaining
+			info.deadUntil = msg.DeadUntil
+			info.lastSeen = time.Now()
+			info.mu.Unlock()
+
+		case "QUERY":
+			go c.serveQuery(info, msg)
+
+		case "QUERY_RESULT":
+			c.mu.Lock()
+			ch, ok := c.pending[msg.RequestID]
+			c.mu.Unlock()
+			if ok {
+				ch <- msg
+			}
+		}
+	}
+}
+
+// serveQuery answers a peer's proxied QUERY by running the search
+// locally (through the same cache/engine path a local request would
+// take) and sending back QUERY_RESULT.
+func (c *cluster) serveQuery(peer *peerInfo, msg peerMessage) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	imgs, err := fetchImages(ctx, msg.Query, msg.CX, msg.Limit, msg.Engines, msg.Mode)
+
+	result := peerMessage{
+		Type:      "QUERY_RESULT",
+		NodeID:    c.selfID,
+		RequestID: msg.RequestID,
+		Images:    imgs,
+	}
+	if err != nil {
+		result.Err = err.Error()
+	}
+	signMessage(&result, c.secret)
+
+	peer.mu.Lock()
+	writeMessage(peer.writer, result)
+	peer.mu.Unlock()
+}
+
+func (c *cluster) heartbeatLoop() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		pool := getGooglePool()
+		if pool == nil {
+			continue
+		}
+		metrics := pool.metrics()
+		var remaining float64
+		deadUntil := make(map[string]string)
+		for _, m := range metrics {
+			remaining += m.Remaining
+			if m.DeadUntil != "" {
+				deadUntil[m.Key] = m.DeadUntil
+			}
+		}
+
+		state := peerMessage{Type: "QUOTA_STATE", NodeID: c.selfID, Remaining: remaining, DeadUntil: deadUntil}
+		signMessage(&state, c.secret)
+
+		c.mu.Lock()
+		peers := make([]*peerInfo, 0, len(c.peers))
+		for _, p := range c.peers {
+			peers = append(peers, p)
+		}
+		c.mu.Unlock()
+
+		for _, p := range peers {
+			p.mu.Lock()
+			writeMessage(p.writer, state)
+			p.mu.Unlock()
+		}
+	}
+}
+
+/* =========================
+   OWNERSHIP + PROXYING
+========================= */
+
+// ownerFor hashes (cx, query) to one of the known live nodes (self plus
+// connected peers), skipping any whose last reported quota state shows
+// it fully exhausted.
+func (c *cluster) ownerFor(cx, query string) string {
+	c.mu.Lock()
+	nodes := make([]string, 0, len(c.peers)+1)
+	nodes = append(nodes, c.selfID)
+	exhausted := map[string]bool{}
+	for id, p := range c.peers {
+		nodes = append(nodes, id)
+		p.mu.Lock()
+		exhausted[id] = p.remaining <= 0 && !p.lastSeen.IsZero()
+		p.mu.Unlock()
+	}
+	c.mu.Unlock()
+
+	sort.Strings(nodes)
+
+	h := fnv.New32a()
+	h.Write([]byte(cx + "|" + normalizeQuery(query)))
+	start := int(h.Sum32()) % len(nodes)
+	if start < 0 {
+		start += len(nodes)
+	}
+
+	for i := 0; i < len(nodes); i++ {
+		candidate := nodes[(start+i)%len(nodes)]
+		if candidate == c.selfID || !exhausted[candidate] {
+			return candidate
+		}
+	}
+	return nodes[start]
+}
+
+func (c *cluster) proxyQuery(ctx context.Context, peerID, query, cx string, limit int, engines []string, mode string) ([]string, error) {
+	c.mu.Lock()
+	peer, ok := c.peers[peerID]
+	reqID := fmt.Sprintf("%s-%d", c.selfID, atomic.AddInt64(&c.reqCounter, 1))
+	ch := make(chan peerMessage, 1)
+	c.pending[reqID] = ch
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, reqID)
+		c.mu.Unlock()
+	}()
+
+	if !ok {
+		return nil, fmt.Errorf("cluster: peer %s not connected", peerID)
+	}
+
+	msg := peerMessage{
+		Type:      "QUERY",
+		NodeID:    c.selfID,
+		RequestID: reqID,
+		Query:     query,
+		CX:        cx,
+		Limit:     limit,
+		Engines:   engines,
+		Mode:      mode,
+	}
+	signMessage(&msg, c.secret)
+
+	peer.mu.Lock()
+	err := writeMessage(peer.writer, msg)
+	peer.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case result := <-ch:
+		if result.Err != "" {
+			return nil, fmt.Errorf("%s", result.Err)
+		}
+		return result.Images, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fetchImagesDistributed routes a query to whichever cluster node owns
+// (cx, query), proxying over the peer link when it isn't this node.
+// With no cluster configured it's a thin pass-through to fetchImages.
+func fetchImagesDistributed(ctx context.Context, query, cx string, limit int, engines []string, mode string) ([]string, error) {
+	c := getCluster()
+	if c == nil {
+		return fetchImages(ctx, query, cx, limit, engines, mode)
+	}
+
+	owner := c.ownerFor(cx, query)
+	if owner == c.selfID {
+		return fetchImages(ctx, query, cx, limit, engines, mode)
+	}
+	return c.proxyQuery(ctx, owner, query, cx, limit, engines, mode)
+}
+
+/* =========================
+   /cluster ENDPOINT
+========================= */
+
+func clusterHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+
+	c := getCluster()
+	if c == nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"enabled": false})
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	type peerStatus struct {
+		ID        string  `json:"id"`
+		Connected bool    `json:"connected"`
+		Remaining float64 `json:"remaining"`
+		LastSeen  string  `json:"last_seen,omitempty"`
+	}
+
+	pool := getGooglePool()
+	selfRemaining := 0.0
+	if pool != nil {
+		for _, m := range pool.metrics() {
+			selfRemaining += m.Remaining
+		}
+	}
+
+	aggregate := selfRemaining
+	peers := make([]peerStatus, 0, len(c.peers))
+	for id, p := range c.peers {
+		p.mu.Lock()
+		status := peerStatus{ID: id, Connected: true, Remaining: p.remaining}
+		if !p.lastSeen.IsZero() {
+			status.LastSeen = p.lastSeen.Format(time.RFC3339)
+		}
+		p.mu.Unlock()
+		aggregate += status.Remaining
+		peers = append(peers, status)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":             true,
+		"self":                c.selfID,
+		"peers":               peers,
+		"aggregate_remaining": aggregate,
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import "testing"
+
+func TestSignVerifyMessage(t *testing.T) {
+	msg := peerMessage{Type: "QUERY_RESULT", NodeID: "node-a", RequestID: "req-1", Images: []string{"https://example.com/a.jpg"}}
+	signMessage(&msg, "s3cr3t")
+
+	if !verifyMessage(msg, "s3cr3t") {
+		t.Fatal("verifyMessage: correctly signed message should verify")
+	}
+	if verifyMessage(msg, "wrong-secret") {
+		t.Fatal("verifyMessage: message should not verify under the wrong secret")
+	}
+}
+
+func TestVerifyMessage_DetectsTamperedFields(t *testing.T) {
+	cases := []struct {
+		name   string
+		tamper func(*peerMessage)
+	}{
+		{"images", func(m *peerMessage) { m.Images = []string{"https://evil.example/x.jpg"} }},
+		{"err", func(m *peerMessage) { m.Err = "injected error" }},
+		{"remaining", func(m *peerMessage) { m.Remaining = 9999 }},
+		{"dead_until", func(m *peerMessage) { m.DeadUntil = map[string]string{"key": "never"} }},
+		{"limit", func(m *peerMessage) { m.Limit = 999 }},
+		{"mode", func(m *peerMessage) { m.Mode = "merge" }},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg := peerMessage{
+				Type:      "QUERY_RESULT",
+				NodeID:    "node-a",
+				RequestID: "req-1",
+				Query:     "cats",
+				CX:        "cx-1",
+				Limit:     5,
+				Images:    []string{"https://example.com/a.jpg"},
+			}
+			signMessage(&msg, "s3cr3t")
+
+			tc.tamper(&msg)
+
+			if verifyMessage(msg, "s3cr3t") {
+				t.Fatalf("verifyMessage: tampering with %s should invalidate the signature", tc.name)
+			}
+		})
+	}
+}
+
+func TestOwnerFor_Deterministic(t *testing.T) {
+	c := &cluster{
+		selfID: "node-a",
+		peers: map[string]*peerInfo{
+			"node-b": {id: "node-b"},
+			"node-c": {id: "node-c"},
+		},
+	}
+
+	first := c.ownerFor("cx-1", "golden retriever")
+	for i := 0; i < 10; i++ {
+		if got := c.ownerFor("cx-1", "golden retriever"); got != first {
+			t.Fatalf("ownerFor: got %q on repeat call, want stable %q", got, first)
+		}
+	}
+}
+
+func TestOwnerFor_SkipsExhaustedPeer(t *testing.T) {
+	c := &cluster{
+		selfID: "self",
+		peers: map[string]*peerInfo{
+			"peer": {id: "peer", remaining: 0, lastSeen: nextMidnightPacific()},
+		},
+	}
+
+	queries := []string{"cats", "dogs", "golden retriever", "tabby cat", "beagle", "parrot", "hamster", "iguana"}
+	for _, q := range queries {
+		if owner := c.ownerFor("cx-1", q); owner != "self" {
+			t.Fatalf("ownerFor(%q): got %q, want self since the only peer is exhausted", q, owner)
+		}
+	}
+}
+
+func TestOwnerFor_NoPeersReturnsSelf(t *testing.T) {
+	c := &cluster{selfID: "self", peers: map[string]*peerInfo{}}
+	if owner := c.ownerFor("cx-1", "cats"); owner != "self" {
+		t.Fatalf("ownerFor with no peers: got %q, want self", owner)
+	}
+}
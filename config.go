@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+/* =========================
+   CONFIG FILE
+========================= */
+
+// Config lets keys, the category→CX map, per-category engine preference
+// and cache TTLs be declared in one file instead of env vars, and
+// hot-reloaded without a restart.
+type Config struct {
+	Keys            []string            `json:"keys"`
+	CategoryCX      map[string]string   `json:"category_cx"`
+	CategoryEngines map[string][]string `json:"category_engines"`
+	RateLimit       RateLimitConfig     `json:"rate_limit"`
+	CacheFreshTTL   string              `json:"cache_fresh_ttl"`
+	CacheStaleTTL   string              `json:"cache_stale_ttl"`
+
+	// Cluster fields let several duckduckgo_image instances, each holding
+	// its own disjoint set of Google keys, cooperate as one logical pool.
+	SelfAddr          string   `json:"self_addr"`           // address peers use to reach this node, also doubles as its node id
+	ClusterListenAddr string   `json:"cluster_listen_addr"` // e.g. ":9000"; empty disables the peer server
+	Peers             []string `json:"peers"`               // other nodes' self_addr values
+	ClusterSecret     string   `json:"cluster_secret"`      // shared HMAC secret authenticating peer messages
+}
+
+// RateLimitConfig overrides the per-key token-bucket defaults in
+// keypool.go. Either field left at zero keeps that default.
+type RateLimitConfig struct {
+	DailyQuota int `json:"daily_quota"`
+	Burst      int `json:"burst"`
+}
+
+var (
+	currentConfig *Config
+	configMu      sync.Mutex
+	configPath    = "config.json"
+)
+
+func loadConfigFile(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// applyConfig swaps the active config in under configMu, and rebuilds
+// the key pool under keyMu so no request ever observes a half-updated
+// state.
+func applyConfig(cfg *Config) {
+	configMu.Lock()
+	currentConfig = cfg
+	configMu.Unlock()
+
+	keys := cfg.Keys
+	if len(keys) == 0 {
+		if pool := getGooglePool(); pool != nil {
+			keys = pool.keys()
+		}
+	}
+	if len(keys) > 0 {
+		setGooglePool(newKeyPool(keys, cfg.RateLimit.DailyQuota, cfg.RateLimit.Burst))
+	}
+	var freshOverride, staleOverride time.Duration
+	if cfg.CacheFreshTTL != "" {
+		if d, err := time.ParseDuration(cfg.CacheFreshTTL); err == nil {
+			freshOverride = d
+		}
+	}
+	if cfg.CacheStaleTTL != "" {
+		if d, err := time.ParseDuration(cfg.CacheStaleTTL); err == nil {
+			staleOverride = d
+		}
+	}
+	setCacheTTLs(freshOverride, staleOverride)
+
+	applyClusterConfig(cfg)
+}
+
+// clusterSignature captures every field that changes what startCluster
+// would build, so applyConfig can tell a routine reload (only
+// category_cx changed, say) from one that actually needs to rebuild the
+// cluster's listener/dial goroutines.
+func clusterSignature(cfg *Config) string {
+	return cfg.SelfAddr + "|" + cfg.ClusterListenAddr + "|" + cfg.ClusterSecret + "|" + strings.Join(cfg.Peers, ",")
+}
+
+var (
+	lastClusterSig   string
+	lastClusterSigMu sync.Mutex
+)
+
+// applyClusterConfig (re)starts the cluster only when cluster-relevant
+// fields actually changed since the last config load, and tears it down
+// if they were removed. This keeps an unrelated hot-reload (e.g. just
+// category_cx) from leaking a fresh listener/dial/heartbeat goroutine
+// set and orphaning the old ones — see startCluster/cluster.stop.
+func applyClusterConfig(cfg *Config) {
+	sig := clusterSignature(cfg)
+
+	lastClusterSigMu.Lock()
+	changed := sig != lastClusterSig
+	lastClusterSig = sig
+	lastClusterSigMu.Unlock()
+
+	if !changed {
+		return
+	}
+
+	if cfg.ClusterListenAddr != "" || len(cfg.Peers) > 0 {
+		startCluster(cfg)
+		return
+	}
+
+	if c := getCluster(); c != nil {
+		c.stop()
+		activeClusterMu.Lock()
+		activeCluster = nil
+		activeClusterMu.Unlock()
+	}
+}
+
+func getConfig() *Config {
+	configMu.Lock()
+	defer configMu.Unlock()
+	return currentConfig
+}
+
+// resolveCX returns the CX id for a category, preferring the loaded
+// config's category_cx map and falling back to the legacy env-var
+// indirection when no config file is active.
+func resolveCX(category string) string {
+	if cfg := getConfig(); cfg != nil {
+		return cfg.CategoryCX[category]
+	}
+	cxEnv, ok := defaultCategoryCX[category]
+	if !ok {
+		return ""
+	}
+	return os.Getenv(cxEnv)
+}
+
+// resolveCategoryEngines returns the configured engine preference for a
+// category, or nil if none is set (callers fall back to ["google"]).
+func resolveCategoryEngines(category string) []string {
+	if cfg := getConfig(); cfg != nil {
+		return cfg.CategoryEngines[category]
+	}
+	return nil
+}
+
+func knownCategories() []string {
+	if cfg := getConfig(); cfg != nil {
+		categories := make([]string, 0, len(cfg.CategoryCX))
+		for category := range cfg.CategoryCX {
+			categories = append(categories, category)
+		}
+		return categories
+	}
+	categories := make([]string, 0, len(defaultCategoryCX))
+	for category := range defaultCategoryCX {
+		categories = append(categories, category)
+	}
+	return categories
+}
+
+/* =========================
+   CONFIG ENDPOINTS
+========================= */
+
+func redactedConfig() map[string]interface{} {
+	cfg := getConfig()
+	if cfg == nil {
+		return map[string]interface{}{"loaded": false}
+	}
+	redactedKeys := make([]string, len(cfg.Keys))
+	for i, k := range cfg.Keys {
+		redactedKeys[i] = maskKey(k)
+	}
+	return map[string]interface{}{
+		"loaded":           true,
+		"keys":             redactedKeys,
+		"category_cx":      cfg.CategoryCX,
+		"category_engines": cfg.CategoryEngines,
+		"rate_limit":       cfg.RateLimit,
+		"cache_fresh_ttl":  cfg.CacheFreshTTL,
+		"cache_stale_ttl":  cfg.CacheStaleTTL,
+	}
+}
+
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(redactedConfig())
+}
+
+func configReloadHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", 405)
+		return
+	}
+	cfg, err := loadConfigFile(configPath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("reload failed: %v", err), 500)
+		return
+	}
+	applyConfig(cfg)
+	w.WriteHeader(204)
+}
+
+/* =========================
+   CONFIG HOT-RELOAD
+========================= */
+
+// watchConfig reloads the config whenever the file (or the directory
+// entry backing it, to survive editors that replace-on-save) changes.
+// It runs for the life of the process; fsnotify failures (e.g. some
+// container bind mounts don't support inotify) are silently ignored —
+// POST /config/reload covers that case.
+func watchConfig(path string) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		return
+	}
+
+	target := filepath.Clean(path)
+	for event := range watcher.Events {
+		if filepath.Clean(event.Name) != target {
+			continue
+		}
+		if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+			continue
+		}
+		cfg, err := loadConfigFile(path)
+		if err != nil {
+			continue
+		}
+		applyConfig(cfg)
+		fmt.Println("config reloaded from", path)
+	}
+}
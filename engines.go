@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/Bossnicks/duckduckgo_image/engines"
+)
+
+/* =========================
+   GOOGLE ENGINE
+========================= */
+
+// googleEngine wraps the existing Custom Search key-pool lookup. cx is
+// resolved per category before the engine is constructed. It lives here
+// rather than in package engines because it depends on the key pool,
+// which is main-package internal state.
+type googleEngine struct {
+	cx string
+}
+
+func (googleEngine) Name() string { return "google" }
+
+func (g googleEngine) Search(ctx context.Context, query string, limit int) ([]string, error) {
+	return getImages(ctx, query, g.cx, limit)
+}
+
+/* =========================
+   ENGINE DISPATCH
+========================= */
+
+func buildEngine(name, cx string) engines.ImageEngine {
+	switch name {
+	case "ddg":
+		return engines.NewDDGEngine()
+	case "searxng":
+		return engines.NewSearXNGEngine()
+	case "quant":
+		return engines.NewQuantEngine()
+	case "imgur":
+		return engines.NewImgurEngine(os.Getenv("IMGUR_CLIENT_ID"))
+	default:
+		return googleEngine{cx: cx}
+	}
+}
+
+// searchWithEngines tries engineNames in order, falling through on
+// quota/429/empty results. When mode is "merge" it queries every engine
+// and concatenates whatever each one returns instead of stopping at the
+// first success.
+func searchWithEngines(ctx context.Context, query, cx string, limit int, engineNames []string, mode string) ([]string, error) {
+	if len(engineNames) == 0 {
+		engineNames = []string{"google"}
+	}
+
+	var merged []string
+	var lastErr error
+
+	for _, name := range engineNames {
+		engine := buildEngine(name, cx)
+		imgs, err := engine.Search(ctx, query, limit)
+		if err != nil {
+			lastErr = err
+			if engines.IsTransient(err) {
+				continue
+			}
+			if mode != "merge" {
+				return nil, err
+			}
+			continue
+		}
+
+		if mode == "merge" {
+			merged = append(merged, imgs...)
+			continue
+		}
+		return imgs, nil
+	}
+
+	if mode == "merge" && len(merged) > 0 {
+		return merged, nil
+	}
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return nil, fmt.Errorf("all engines exhausted")
+}
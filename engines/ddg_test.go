@@ -0,0 +1,95 @@
+package engines
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Recorded fixtures, trimmed down from real DuckDuckGo responses.
+const ddgHTMLFixture = `<html><head></head><body>vqd="3-1234567890-abc"</body></html>`
+
+const ddgJSFixture = `{
+	"results": [
+		{"image": "https://example.com/a.jpg"},
+		{"image": "https://example.com/b.jpg"}
+	]
+}`
+
+func TestDDGEngine_Search(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(ddgHTMLFixture))
+		case "/i.js":
+			w.Write([]byte(ddgJSFixture))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	e := DDGEngine{BaseURL: srv.URL}
+	images, err := e.Search(context.Background(), "cats", 5)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(images) != 2 {
+		t.Fatalf("got %d images, want 2", len(images))
+	}
+	if images[0] != "https://example.com/a.jpg" {
+		t.Errorf("images[0] = %q", images[0])
+	}
+}
+
+func TestDDGEngine_SearchRespectsLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(ddgHTMLFixture))
+		case "/i.js":
+			w.Write([]byte(ddgJSFixture))
+		}
+	}))
+	defer srv.Close()
+
+	e := DDGEngine{BaseURL: srv.URL}
+	images, err := e.Search(context.Background(), "cats", 1)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(images) != 1 {
+		t.Fatalf("got %d images, want 1", len(images))
+	}
+}
+
+func TestDDGEngine_NoVQDToken(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>no token here</html>"))
+	}))
+	defer srv.Close()
+
+	e := DDGEngine{BaseURL: srv.URL}
+	if _, err := e.Search(context.Background(), "cats", 5); err == nil {
+		t.Fatal("expected error when vqd token is missing")
+	}
+}
+
+func TestDDGEngine_EmptyResultsIsTransient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(ddgHTMLFixture))
+		case "/i.js":
+			w.Write([]byte(`{"results": []}`))
+		}
+	}))
+	defer srv.Close()
+
+	e := DDGEngine{BaseURL: srv.URL}
+	_, err := e.Search(context.Background(), "cats", 5)
+	if !IsTransient(err) {
+		t.Fatalf("expected a transient error for empty results, got %v", err)
+	}
+}
@@ -0,0 +1,420 @@
+// Package engines holds the self-contained image-search backends that
+// don't need access to duckduckgo_image's Google key pool: DuckDuckGo,
+// SearXNG, Qwant ("Quant"), and Imgur. Google stays in package main since
+// googleEngine there is tied to the key pool.
+package engines
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ImageEngine is anything that can turn a query into a list of image
+// URLs. Matches the interface in package main structurally so googleEngine
+// there satisfies it without importing this package.
+type ImageEngine interface {
+	Name() string
+	Search(ctx context.Context, query string, limit int) ([]string, error)
+}
+
+// ErrNoResults lets callers treat an empty-but-successful response the
+// same way as a quota/429 error: fall through to the next engine.
+var ErrNoResults = fmt.Errorf("engine returned no results")
+
+// statusError carries an upstream HTTP status code so IsTransient can
+// classify a non-200 response without guessing at the error message's
+// wording, which varies by engine and by what the upstream put in its body.
+type statusError struct {
+	statusCode int
+	msg        string
+}
+
+func (e *statusError) Error() string { return e.msg }
+
+func newStatusError(engine string, statusCode int, body []byte) error {
+	return &statusError{statusCode: statusCode, msg: fmt.Sprintf("%s error: status %d: %s", engine, statusCode, body)}
+}
+
+/* =========================
+   DUCKDUCKGO ENGINE
+========================= */
+
+// DDGEngine scrapes DuckDuckGo's undocumented image endpoint: first fetch
+// the vqd token from the HTML search page, then query i.js with it.
+// BaseURL defaults to the real DuckDuckGo host; tests override it with an
+// httptest.Server URL.
+type DDGEngine struct {
+	BaseURL string
+}
+
+func NewDDGEngine() DDGEngine {
+	return DDGEngine{BaseURL: "https://duckduckgo.com"}
+}
+
+func (DDGEngine) Name() string { return "ddg" }
+
+func (e DDGEngine) Search(ctx context.Context, query string, limit int) ([]string, error) {
+	client := &http.Client{Timeout: 20 * time.Second}
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet,
+		e.BaseURL+"/?q="+url.QueryEscape(query)+"&iax=images&ia=images", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	vqd := extractVQD(string(body))
+	if vqd == "" {
+		return nil, fmt.Errorf("ddg: could not find vqd token")
+	}
+
+	searchURL := fmt.Sprintf(
+		"%s/i.js?q=%s&vqd=%s&o=json",
+		e.BaseURL, url.QueryEscape(query), vqd,
+	)
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, searchURL, nil)
+	resp, err = client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, newStatusError("ddg", resp.StatusCode, body)
+	}
+
+	var data struct {
+		Results []struct {
+			Image string `json:"image"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, err
+	}
+
+	var images []string
+	for _, r := range data.Results {
+		images = append(images, r.Image)
+		if len(images) >= limit {
+			break
+		}
+	}
+	if len(images) == 0 {
+		return nil, ErrNoResults
+	}
+	return images, nil
+}
+
+func extractVQD(html string) string {
+	marker := "vqd=\""
+	i := strings.Index(html, marker)
+	if i == -1 {
+		return ""
+	}
+	rest := html[i+len(marker):]
+	j := strings.Index(rest, "\"")
+	if j == -1 {
+		return ""
+	}
+	return rest[:j]
+}
+
+/* =========================
+   SEARXNG ENGINE
+========================= */
+
+// SearXNGEngine queries a public SearXNG instance's JSON API. Instances
+// come and go, so we resolve one from the public instance list and cache
+// the pick for a while instead of hammering the list on every search.
+// InstanceListURL and Instance are overridable for tests; production
+// code leaves them zero and gets the real searx.space list / discovery.
+type SearXNGEngine struct {
+	InstanceListURL string
+	Instance        string // if set, skips discovery entirely (used by tests)
+}
+
+func NewSearXNGEngine() *SearXNGEngine {
+	return &SearXNGEngine{InstanceListURL: "https://searx.space/data/instances.json"}
+}
+
+func (*SearXNGEngine) Name() string { return "searxng" }
+
+const searxInstanceTTL = 30 * time.Minute
+
+var (
+	searxInstance   string
+	searxInstanceAt time.Time
+	searxMu         sync.Mutex
+)
+
+func (e *SearXNGEngine) chooseInstance(ctx context.Context) (string, error) {
+	if e.Instance != "" {
+		return e.Instance, nil
+	}
+
+	searxMu.Lock()
+	defer searxMu.Unlock()
+
+	if searxInstance != "" && time.Since(searxInstanceAt) < searxInstanceTTL {
+		return searxInstance, nil
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, e.InstanceListURL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	var list struct {
+		Instances map[string]struct {
+			HTTPS  bool `json:"https"`
+			Uptime struct {
+				Uptime24h float64 `json:"uptimeDay"`
+			} `json:"uptime"`
+		} `json:"instances"`
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return "", err
+	}
+
+	var best string
+	var bestUptime float64
+	for host, inst := range list.Instances {
+		if !inst.HTTPS {
+			continue
+		}
+		if inst.Uptime.Uptime24h > bestUptime {
+			bestUptime = inst.Uptime.Uptime24h
+			best = host
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("searxng: no usable public instance found")
+	}
+
+	searxInstance = strings.TrimSuffix(best, "/")
+	searxInstanceAt = time.Now()
+	return searxInstance, nil
+}
+
+func (e *SearXNGEngine) Search(ctx context.Context, query string, limit int) ([]string, error) {
+	instance, err := e.chooseInstance(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	searchURL := fmt.Sprintf(
+		"%s/search?q=%s&categories=images&format=json",
+		instance, url.QueryEscape(query),
+	)
+	client := &http.Client{Timeout: 20 * time.Second}
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, searchURL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, newStatusError("searxng", resp.StatusCode, body)
+	}
+
+	var data struct {
+		Results []struct {
+			ImgSrc string `json:"img_src"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, err
+	}
+
+	var images []string
+	for _, r := range data.Results {
+		if r.ImgSrc == "" {
+			continue
+		}
+		images = append(images, r.ImgSrc)
+		if len(images) >= limit {
+			break
+		}
+	}
+	if len(images) == 0 {
+		return nil, ErrNoResults
+	}
+	return images, nil
+}
+
+/* =========================
+   QUANT (QWANT) ENGINE
+========================= */
+
+type QuantEngine struct {
+	BaseURL string
+}
+
+func NewQuantEngine() QuantEngine {
+	return QuantEngine{BaseURL: "https://api.qwant.com"}
+}
+
+func (QuantEngine) Name() string { return "quant" }
+
+func (e QuantEngine) Search(ctx context.Context, query string, limit int) ([]string, error) {
+	searchURL := fmt.Sprintf(
+		"%s/v3/search/images?q=%s&count=%d&locale=en_US&safesearch=1",
+		e.BaseURL, url.QueryEscape(query), limit,
+	)
+	client := &http.Client{Timeout: 20 * time.Second}
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, searchURL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, newStatusError("quant", resp.StatusCode, body)
+	}
+
+	var data struct {
+		Data struct {
+			Result struct {
+				Items []struct {
+					Media string `json:"media"`
+				} `json:"items"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, err
+	}
+
+	var images []string
+	for _, item := range data.Data.Result.Items {
+		images = append(images, item.Media)
+		if len(images) >= limit {
+			break
+		}
+	}
+	if len(images) == 0 {
+		return nil, ErrNoResults
+	}
+	return images, nil
+}
+
+/* =========================
+   IMGUR ENGINE
+========================= */
+
+type ImgurEngine struct {
+	BaseURL  string
+	ClientID string
+}
+
+func NewImgurEngine(clientID string) ImgurEngine {
+	return ImgurEngine{BaseURL: "https://api.imgur.com", ClientID: clientID}
+}
+
+func (ImgurEngine) Name() string { return "imgur" }
+
+func (e ImgurEngine) Search(ctx context.Context, query string, limit int) ([]string, error) {
+	if e.ClientID == "" {
+		return nil, fmt.Errorf("imgur: IMGUR_CLIENT_ID not configured")
+	}
+
+	searchURL := e.BaseURL + "/3/gallery/search/top/?q=" + url.QueryEscape(query)
+	client := &http.Client{Timeout: 20 * time.Second}
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, searchURL, nil)
+	req.Header.Set("Authorization", "Client-ID "+e.ClientID)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, newStatusError("imgur", resp.StatusCode, body)
+	}
+
+	var data struct {
+		Data []struct {
+			Link   string `json:"link"`
+			Images []struct {
+				Link string `json:"link"`
+			} `json:"images"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, err
+	}
+
+	var images []string
+	for _, item := range data.Data {
+		if item.Link != "" {
+			images = append(images, item.Link)
+		}
+		for _, img := range item.Images {
+			images = append(images, img.Link)
+		}
+		if len(images) >= limit {
+			break
+		}
+	}
+	if len(images) > limit {
+		images = images[:limit]
+	}
+	if len(images) == 0 {
+		return nil, ErrNoResults
+	}
+	return images, nil
+}
+
+/* =========================
+   ERROR CLASSIFICATION
+========================= */
+
+var fallthroughSubstrings = []string{"quota", "429", "dailylimitexceeded", "userratelimitexceeded"}
+
+// IsTransient reports whether err represents a condition the dispatcher
+// in package main should fall through to the next engine for, rather
+// than surface to the caller. A *statusError is classified on its actual
+// HTTP status code; anything else (Google's key-pool errors, which aren't
+// a *statusError) falls back to the body-wording heuristic below.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == ErrNoResults {
+		return true
+	}
+
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.statusCode == http.StatusTooManyRequests || se.statusCode == http.StatusForbidden
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, s := range fallthroughSubstrings {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package engines
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Recorded fixture, trimmed down from a real Imgur gallery search response.
+const imgurFixture = `{
+	"data": [
+		{"link": "https://imgur.com/gallery/abc", "images": [{"link": "https://i.imgur.com/1.jpg"}]},
+		{"link": "", "images": [{"link": "https://i.imgur.com/2.jpg"}, {"link": "https://i.imgur.com/3.jpg"}]}
+	]
+}`
+
+func TestImgurEngine_Search(t *testing.T) {
+	var gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		w.Write([]byte(imgurFixture))
+	}))
+	defer srv.Close()
+
+	e := ImgurEngine{BaseURL: srv.URL, ClientID: "test-client-id"}
+	images, err := e.Search(context.Background(), "cats", 10)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(images) != 4 {
+		t.Fatalf("got %d images, want 4", len(images))
+	}
+	if gotAuth != "Client-ID test-client-id" {
+		t.Errorf("Authorization header = %q", gotAuth)
+	}
+}
+
+func TestImgurEngine_MissingClientID(t *testing.T) {
+	e := ImgurEngine{BaseURL: "http://unused.invalid"}
+	if _, err := e.Search(context.Background(), "cats", 5); err == nil {
+		t.Fatal("expected an error when ClientID is empty")
+	}
+}
@@ -0,0 +1,49 @@
+package engines
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Recorded fixture, trimmed down from a real Qwant images response.
+const quantFixture = `{
+	"data": {
+		"result": {
+			"items": [
+				{"media": "https://example.com/a.jpg"},
+				{"media": "https://example.com/b.jpg"},
+				{"media": "https://example.com/c.jpg"}
+			]
+		}
+	}
+}`
+
+func TestQuantEngine_Search(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(quantFixture))
+	}))
+	defer srv.Close()
+
+	e := QuantEngine{BaseURL: srv.URL}
+	images, err := e.Search(context.Background(), "cats", 2)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(images) != 2 {
+		t.Fatalf("got %d images, want 2 (limit enforced)", len(images))
+	}
+}
+
+func TestQuantEngine_NonOKStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	e := QuantEngine{BaseURL: srv.URL}
+	if _, err := e.Search(context.Background(), "cats", 5); err == nil {
+		t.Fatal("expected an error for a non-200 response")
+	}
+}
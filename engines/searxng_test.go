@@ -0,0 +1,62 @@
+package engines
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Recorded fixture, trimmed down from a real searx.space instance list.
+const searxInstanceListFixture = `{
+	"instances": {
+		"https://searx.example.org/": {"https": true, "uptime": {"uptimeDay": 99.9}},
+		"http://searx.insecure.example/": {"https": false, "uptime": {"uptimeDay": 100}},
+		"https://searx.flaky.example/": {"https": true, "uptime": {"uptimeDay": 40.0}}
+	}
+}`
+
+const searxResultsFixture = `{
+	"results": [
+		{"img_src": "https://example.com/a.jpg"},
+		{"img_src": ""},
+		{"img_src": "https://example.com/b.jpg"}
+	]
+}`
+
+func TestSearXNGEngine_ChooseInstancePicksBestUptimeHTTPS(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(searxInstanceListFixture))
+	}))
+	defer srv.Close()
+
+	// Reset package-level cache so this test doesn't depend on run order.
+	searxMu.Lock()
+	searxInstance = ""
+	searxMu.Unlock()
+
+	e := &SearXNGEngine{InstanceListURL: srv.URL}
+	instance, err := e.chooseInstance(context.Background())
+	if err != nil {
+		t.Fatalf("chooseInstance: %v", err)
+	}
+	if instance != "https://searx.example.org" {
+		t.Fatalf("chooseInstance = %q, want the highest-uptime https instance", instance)
+	}
+}
+
+func TestSearXNGEngine_Search(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(searxResultsFixture))
+	}))
+	defer srv.Close()
+
+	e := &SearXNGEngine{Instance: srv.URL}
+	images, err := e.Search(context.Background(), "cats", 5)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(images) != 2 {
+		t.Fatalf("got %d images, want 2 (blank img_src entries dropped)", len(images))
+	}
+}
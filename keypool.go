@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+/* =========================
+   GOOGLE KEY POOL
+========================= */
+
+// Custom Search free tier: 100 queries/day per key, with bursts up to
+// roughly 1 QPS. The limiter's steady rate reflects the daily quota; the
+// burst lets a key answer a handful of requests back to back before it
+// has to wait for tokens to refill.
+const (
+	keyDailyQuota = 100
+	keyBurst      = 3
+)
+
+type keyState struct {
+	key       string
+	limiter   *rate.Limiter
+	deadUntil time.Time
+}
+
+type keyPool struct {
+	mu     sync.Mutex
+	states []*keyState
+}
+
+// newKeyPool builds a pool with the given per-key daily quota and burst.
+// A dailyQuota/burst of 0 falls back to the CSE free-tier defaults above,
+// so existing GOOGLE_KEYS-only deployments keep behaving the same way.
+func newKeyPool(keys []string, dailyQuota, burst int) *keyPool {
+	if dailyQuota <= 0 {
+		dailyQuota = keyDailyQuota
+	}
+	if burst <= 0 {
+		burst = keyBurst
+	}
+
+	pool := &keyPool{}
+	for _, k := range keys {
+		pool.states = append(pool.states, &keyState{
+			key:     k,
+			limiter: rate.NewLimiter(rate.Every(24*time.Hour/time.Duration(dailyQuota)), burst),
+		})
+	}
+	return pool
+}
+
+// keys returns the pool's key list, in the order it was constructed with.
+func (p *keyPool) keys() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]string, len(p.states))
+	for i, s := range p.states {
+		out[i] = s.key
+	}
+	return out
+}
+
+// Acquire waits for a key to have a token available and returns it along
+// with a release func. It prefers whichever live key has tokens right
+// now; if none do, it waits on whichever key will recover soonest.
+func (p *keyPool) Acquire(ctx context.Context) (string, func(), error) {
+	state, err := p.pickState()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err := state.limiter.Wait(ctx); err != nil {
+		return "", nil, err
+	}
+
+	release := func() {}
+	return state.key, release, nil
+}
+
+func (p *keyPool) pickState() (*keyState, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	var best *keyState
+	var bestReserve time.Duration
+
+	for _, s := range p.states {
+		if now.Before(s.deadUntil) {
+			continue
+		}
+		if s.limiter.Tokens() >= 1 {
+			return s, nil
+		}
+		r := s.limiter.Reserve()
+		delay := r.Delay()
+		r.Cancel()
+		if best == nil || delay < bestReserve {
+			best = s
+			bestReserve = delay
+		}
+	}
+
+	if best == nil {
+		return nil, fmt.Errorf("all GOOGLE_KEYS exhausted")
+	}
+	return best, nil
+}
+
+// markDead marks key unusable until the given time, e.g. the next
+// midnight Pacific after a dailyLimitExceeded response.
+func (p *keyPool) markDead(key string, until time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, s := range p.states {
+		if s.key == key {
+			s.deadUntil = until
+			return
+		}
+	}
+}
+
+// nextMidnightPacific returns the next occurrence of 00:00 in the
+// America/Los_Angeles time zone, which is when Google resets CSE quotas.
+func nextMidnightPacific() time.Time {
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		loc = time.UTC
+	}
+	now := time.Now().In(loc)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, 1)
+	return midnight
+}
+
+/* =========================
+   GOOGLE ERROR CLASSIFICATION
+========================= */
+
+// classifyGoogleError inspects a Custom Search error body for the reason
+// code Google reports when a key is throttled or exhausted.
+func classifyGoogleError(body string) string {
+	switch {
+	case strings.Contains(body, "dailyLimitExceeded"):
+		return "dailyLimitExceeded"
+	case strings.Contains(body, "userRateLimitExceeded"):
+		return "userRateLimitExceeded"
+	default:
+		return ""
+	}
+}
+
+/* =========================
+   METRICS
+========================= */
+
+type keyMetric struct {
+	Key       string  `json:"key"`
+	Remaining float64 `json:"remaining_tokens"`
+	DeadUntil string  `json:"dead_until,omitempty"`
+}
+
+func maskKey(key string) string {
+	if len(key) <= 8 {
+		return "****"
+	}
+	return key[:4] + "..." + key[len(key)-4:]
+}
+
+func (p *keyPool) metrics() []keyMetric {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	out := make([]keyMetric, 0, len(p.states))
+	for _, s := range p.states {
+		m := keyMetric{
+			Key:       maskKey(s.key),
+			Remaining: s.limiter.Tokens(),
+		}
+		if now.Before(s.deadUntil) {
+			m.DeadUntil = s.deadUntil.Format(time.RFC3339)
+		}
+		out = append(out, m)
+	}
+	return out
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyPool_MarkDeadExcludesKeyUntilExpiry(t *testing.T) {
+	pool := newKeyPool([]string{"key-a", "key-b"}, 1000, 10)
+
+	pool.markDead("key-a", time.Now().Add(time.Hour))
+
+	state, err := pool.pickState()
+	if err != nil {
+		t.Fatalf("pickState: %v", err)
+	}
+	if state.key != "key-b" {
+		t.Fatalf("pickState returned %q, want key-b while key-a is dead", state.key)
+	}
+
+	pool.markDead("key-a", time.Now().Add(-time.Second))
+	for i := 0; i < len(pool.states); i++ {
+		if _, err := pool.pickState(); err != nil {
+			t.Fatalf("pickState after key-a's deadline passed: %v", err)
+		}
+	}
+}
+
+func TestKeyPool_PickStateAllExhausted(t *testing.T) {
+	pool := newKeyPool([]string{"key-a", "key-b"}, 1000, 10)
+
+	future := time.Now().Add(time.Hour)
+	pool.markDead("key-a", future)
+	pool.markDead("key-b", future)
+
+	if _, err := pool.pickState(); err == nil {
+		t.Fatal("pickState: expected an error when every key is dead")
+	}
+}
+
+func TestKeyPool_MarkDeadUnknownKeyIsNoop(t *testing.T) {
+	pool := newKeyPool([]string{"key-a"}, 1000, 10)
+	pool.markDead("not-a-real-key", time.Now().Add(time.Hour))
+
+	if _, err := pool.pickState(); err != nil {
+		t.Fatalf("pickState: %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -15,6 +16,8 @@ import (
 type BatchRequest struct {
 	Queries    []string `json:"queries"`
 	Categories []string `json:"categories"`
+	Engines    []string `json:"engines"` // ordered preference, e.g. ["google", "ddg"]; defaults to ["google"]
+	Mode       string   `json:"mode"`    // "" (first success) or "merge" (concat every engine's results)
 }
 
 type GoogleResponse struct {
@@ -34,24 +37,30 @@ func enableCORS(w http.ResponseWriter) {
 ========================= */
 
 var (
-	googleKeys []string
-	keyIndex   int
+	googlePool *keyPool
 	keyMu      sync.Mutex
 )
 
-func nextKey() string {
+func getGooglePool() *keyPool {
 	keyMu.Lock()
 	defer keyMu.Unlock()
-	key := googleKeys[keyIndex]
-	keyIndex = (keyIndex + 1) % len(googleKeys)
-	return key
+	return googlePool
+}
+
+func setGooglePool(p *keyPool) {
+	keyMu.Lock()
+	defer keyMu.Unlock()
+	googlePool = p
 }
 
 /* =========================
    CATEGORY → CX MAP
+
+   defaultCategoryCX is used when no config.json is present, resolving
+   through the legacy per-category env vars.
 ========================= */
 
-var categoryCX = map[string]string{
+var defaultCategoryCX = map[string]string{
 	"Недвижимость":           "CX_REAL_ESTATE",
 	"Транспорт":              "CX_TRANSPORT",
 	"Спец/сельхоз техника":   "CX_SPECIAL_TECH",
@@ -68,9 +77,13 @@ var categoryCX = map[string]string{
    GOOGLE IMAGE SEARCH
 ========================= */
 
-func getImages(query, cx string, limit int) ([]string, error) {
-	for i := 0; i < len(googleKeys); i++ {
-		key := nextKey()
+func getImages(ctx context.Context, query, cx string, limit int) ([]string, error) {
+	pool := getGooglePool()
+	for {
+		key, release, err := pool.Acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
 
 		searchURL := fmt.Sprintf(
 			"https://www.googleapis.com/customsearch/v1?"+
@@ -86,14 +99,20 @@ func getImages(query, cx string, limit int) ([]string, error) {
 		client := &http.Client{Timeout: 20 * time.Second}
 		resp, err := client.Get(searchURL)
 		if err != nil {
+			release()
 			continue
 		}
 
 		body, _ := ioutil.ReadAll(resp.Body)
 		resp.Body.Close()
+		release()
 
-		if resp.StatusCode == 429 || strings.Contains(string(body), "quota") {
-			continue // переключаем ключ
+		reason := classifyGoogleError(string(body))
+		if resp.StatusCode == 429 || resp.StatusCode == 403 || reason != "" {
+			if reason == "dailyLimitExceeded" {
+				pool.markDead(key, nextMidnightPacific())
+			}
+			continue // попробуем другой ключ
 		}
 
 		if resp.StatusCode != 200 {
@@ -111,8 +130,6 @@ func getImages(query, cx string, limit int) ([]string, error) {
 		}
 		return images, nil
 	}
-
-	return nil, fmt.Errorf("all GOOGLE_KEYS exhausted")
 }
 
 /* =========================
@@ -145,31 +162,29 @@ func batchHandler(w http.ResponseWriter, r *http.Request) {
 		query := strings.TrimSpace(req.Queries[i])
 		category := strings.TrimSpace(req.Categories[i])
 
-		cxEnv, ok := categoryCX[category]
-		if !ok {
+		cx := resolveCX(category)
+		if cx == "" {
 			continue
 		}
 
-		cx := os.Getenv(cxEnv)
-		if cx == "" {
-			continue
+		engines := req.Engines
+		if len(engines) == 0 {
+			engines = resolveCategoryEngines(category)
 		}
 
 		wg.Add(1)
-		go func(q, cx string) {
+		go func(q, cx string, engines []string) {
 			defer wg.Done()
 			sem <- struct{}{}
 			defer func() { <-sem }()
 
-			imgs, err := getImages(q+" photo", cx, 5)
+			imgs, err := fetchImagesDistributed(r.Context(), q+" photo", cx, 5, engines, req.Mode)
 			if err == nil {
 				mu.Lock()
 				results[q] = imgs
 				mu.Unlock()
 			}
-
-			time.Sleep(1000 * time.Millisecond)
-		}(query, cx)
+		}(query, cx, engines)
 	}
 
 	wg.Wait()
@@ -181,15 +196,37 @@ func batchHandler(w http.ResponseWriter, r *http.Request) {
    MAIN
 ========================= */
 
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(getGooglePool().metrics())
+}
+
 func main() {
-	keys := os.Getenv("GOOGLE_KEYS")
-	if keys == "" {
-		panic("GOOGLE_KEYS not set")
+	if v := os.Getenv("CONFIG_FILE"); v != "" {
+		configPath = v
+	}
+
+	if cfg, err := loadConfigFile(configPath); err == nil {
+		applyConfig(cfg)
+		go watchConfig(configPath)
+	}
+
+	if getGooglePool() == nil {
+		keys := os.Getenv("GOOGLE_KEYS")
+		if keys == "" {
+			panic("GOOGLE_KEYS not set")
+		}
+		setGooglePool(newKeyPool(strings.Split(keys, ","), 0, 0))
 	}
-	googleKeys = strings.Split(keys, ",")
 
 	http.Handle("/", http.FileServer(http.Dir("./static")))
 	http.HandleFunc("/batch", batchHandler)
+	http.HandleFunc("/metrics", metricsHandler)
+	http.HandleFunc("/cache", cacheAdminHandler)
+	http.HandleFunc("/config", configHandler)
+	http.HandleFunc("/config/reload", configReloadHandler)
+	http.HandleFunc("/cluster", clusterHandler)
 
 	fmt.Println("Server started on http://localhost:8888")
 	http.ListenAndServe(":8888", nil)